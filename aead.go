@@ -0,0 +1,182 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// AEADAlgo identifies an authenticated-encryption-with-associated-data
+// algorithm that SetAEAD can install on a ResponseWriter.
+type AEADAlgo uint8
+
+const (
+	// AEADAESGCM seals frames with AES-GCM (the key selects AES-128,
+	// AES-192 or AES-256, per crypto/aes.NewCipher).
+	AEADAESGCM AEADAlgo = iota + 1
+	// AEADChaCha20Poly1305 seals frames with ChaCha20-Poly1305.
+	AEADChaCha20Poly1305
+)
+
+// aeadFrameSize is the largest amount of plaintext sealed into a single
+// frame; writeAEAD splits anything bigger across several frames of this
+// size. It is not a promise that every frame but the last is exactly
+// this size: writeAEAD also seals whatever's left over at the end of
+// every Write call (see writeAEAD), so a handler that calls Write
+// several times gets one short frame per call rather than silently
+// losing whatever didn't fill a complete frame. Each frame is prefixed
+// with its own length on the wire (see sealFrame) precisely so those
+// short, mid-stream frames are unambiguous to a reader.
+const aeadFrameSize = 64 * 1024
+
+var (
+	errAEADHijack        = errors.New("gin: cannot hijack a connection while AEAD encryption is active")
+	errAEADUnalignedSeek = errors.New("gin: SetStartIndex must be frame-aligned while AEAD encryption is active")
+	errUnsupportedAEAD   = errors.New("gin: unsupported AEAD algorithm")
+	aeadFrameMagic       = [4]byte{'G', 'A', 'E', 'F'} // "Gin AEAD Framing"
+)
+
+// aeadFrameHeaderVer is 2 because version 1 frames had no length prefix
+// and assumed every frame but the last was exactly aeadFrameSize, which
+// made it impossible to seal a short frame anywhere but at the very end
+// of the body — something responseWriter has no reliable way to detect
+// (see writeAEAD).
+const aeadFrameHeaderVer = byte(2)
+
+// aeadState holds everything responseWriter needs to frame and seal a
+// response body under an AEAD instead of the raw CTR keystream. It is
+// zero-valued (aead == nil) for the common, non-AEAD case.
+type aeadState struct {
+	aead        cipher.AEAD
+	aeadAlgo    AEADAlgo
+	aeadBase    []byte // base nonce, aead.NonceSize() bytes
+	aeadFrame   uint64 // index of the next frame to seal
+	aeadStarted bool   // header written
+}
+
+// newAEAD constructs the cipher.AEAD for algo with the given key.
+func newAEAD(algo AEADAlgo, key []byte) (cipher.AEAD, error) {
+	switch algo {
+	case AEADAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AEADChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, errUnsupportedAEAD
+	}
+}
+
+// SetAEAD installs algo as the encryption scheme used by future Writes,
+// replacing the plain CTR mode enabled by EnableEncryption. nonce is the
+// base nonce; each frame derives its own nonce by XORing a big-endian
+// frame counter into its low 8 bytes, so nonce must never be reused
+// across responses for the same key.
+func (w *responseWriter) SetAEAD(algo AEADAlgo, key, nonce []byte) error {
+	aead, err := newAEAD(algo, key)
+	if err != nil {
+		return err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return fmt.Errorf("gin: nonce must be %d bytes for this AEAD, got %d", aead.NonceSize(), len(nonce))
+	}
+	w.aead = aead
+	w.aeadAlgo = algo
+	w.aeadBase = append([]byte(nil), nonce...)
+	w.aeadFrame = 0
+	w.aeadStarted = false
+	w.enableEncryption = false // AEAD and raw CTR are mutually exclusive
+	return nil
+}
+
+// writeAEAD seals data into one or more frames and reports len(data)
+// written on success. It never holds plaintext over to a future Write
+// call: a Write of more than aeadFrameSize bytes is split into
+// aeadFrameSize chunks, and whatever remains — even a handful of bytes —
+// is sealed as its own short frame before writeAEAD returns. That is
+// deliberate: most handlers (c.JSON, c.String, c.Data, ...) make exactly
+// one Write call with the whole body and return without ever calling
+// Flush or setting Content-Length, so waiting for either of those as an
+// end-of-body signal silently drops the entire response. Sealing
+// eagerly needs no such signal; the cost is more (and smaller) frames
+// for callers that stream a body across many small Writes.
+func (w *responseWriter) writeAEAD(data []byte) (int, error) {
+	if !w.aeadStarted && w.startIndex%aeadFrameSize != 0 {
+		return 0, errAEADUnalignedSeek
+	}
+	if !w.aeadStarted {
+		if err := w.writeAEADHeader(); err != nil {
+			return 0, err
+		}
+		w.aeadFrame = w.startIndex / aeadFrameSize
+		w.aeadStarted = true
+	}
+
+	total := len(data)
+	for len(data) > aeadFrameSize {
+		if err := w.sealFrame(data[:aeadFrameSize]); err != nil {
+			return 0, err
+		}
+		data = data[aeadFrameSize:]
+	}
+	if len(data) > 0 {
+		if err := w.sealFrame(data); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// writeAEADHeader emits the framing header that lets a client discover
+// the algorithm, maximum frame size and base nonce before the first
+// frame.
+func (w *responseWriter) writeAEADHeader() error {
+	header := make([]byte, 0, 4+1+1+4+1+len(w.aeadBase))
+	header = append(header, aeadFrameMagic[:]...)
+	header = append(header, aeadFrameHeaderVer)
+	header = append(header, byte(w.aeadAlgo))
+	frameSizeBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(frameSizeBytes, uint32(aeadFrameSize))
+	header = append(header, frameSizeBytes...)
+	header = append(header, byte(len(w.aeadBase)))
+	header = append(header, w.aeadBase...)
+	_, err := w.ResponseWriter.Write(header)
+	return err
+}
+
+// sealFrame seals one frame of plaintext with a nonce derived from the
+// base nonce and the current frame counter, writes a 4-byte big-endian
+// length prefix followed by the sealed bytes to the underlying writer,
+// and advances the counter. The length prefix is what lets a reader
+// consume a frame shorter than aeadFrameSize correctly no matter where
+// in the stream it falls, since writeAEAD no longer guarantees that only
+// the very last frame is short.
+func (w *responseWriter) sealFrame(plaintext []byte) error {
+	nonce := append([]byte(nil), w.aeadBase...)
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ w.aeadFrame
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+
+	sealed := w.aead.Seal(nil, nonce, plaintext, nil)
+
+	lenPrefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenPrefix, uint32(len(sealed)))
+	if _, err := w.ResponseWriter.Write(lenPrefix); err != nil {
+		return err
+	}
+	if _, err := w.ResponseWriter.Write(sealed); err != nil {
+		return err
+	}
+	w.aeadFrame++
+	return nil
+}
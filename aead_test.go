@@ -0,0 +1,202 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http/httptest"
+	"testing"
+)
+
+// decodeAEADWire parses the framing header and every length-prefixed
+// frame off wire, decrypts each frame and returns the concatenated
+// plaintext plus how many frames it found. It re-implements the client
+// side of the wire format rather than reusing sealFrame, so it actually
+// exercises what a real decoder would see.
+func decodeAEADWire(t *testing.T, wire []byte, key, nonce []byte) (plaintext []byte, frameCount int) {
+	t.Helper()
+
+	headerLen := 4 + 1 + 1 + 4 + 1 + len(nonce)
+	if len(wire) < headerLen {
+		t.Fatalf("wire too short for the framing header: got %d bytes, want at least %d", len(wire), headerLen)
+	}
+	if !bytes.Equal(wire[:4], aeadFrameMagic[:]) {
+		t.Fatalf("bad framing magic: %v", wire[:4])
+	}
+	pos := 4
+	if ver := wire[pos]; ver != aeadFrameHeaderVer {
+		t.Fatalf("unexpected framing version: got %d, want %d", ver, aeadFrameHeaderVer)
+	}
+	pos++
+	algo := AEADAlgo(wire[pos])
+	pos++
+	pos += 4 // declared max frame size, unused by this decoder
+	nonceLen := int(wire[pos])
+	pos++
+	baseNonce := wire[pos : pos+nonceLen]
+	pos += nonceLen
+	if !bytes.Equal(baseNonce, nonce) {
+		t.Fatalf("base nonce in the header didn't match what was passed to SetAEAD")
+	}
+
+	aead, err := newAEAD(algo, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frame uint64
+	for pos < len(wire) {
+		if pos+4 > len(wire) {
+			t.Fatalf("truncated length prefix at frame %d", frame)
+		}
+		n := binary.BigEndian.Uint32(wire[pos : pos+4])
+		pos += 4
+		if pos+int(n) > len(wire) {
+			t.Fatalf("truncated frame %d: declared %d bytes, only %d remain", frame, n, len(wire)-pos)
+		}
+		sealed := wire[pos : pos+int(n)]
+		pos += int(n)
+
+		frameNonce := append([]byte(nil), baseNonce...)
+		counter := binary.BigEndian.Uint64(frameNonce[len(frameNonce)-8:]) ^ frame
+		binary.BigEndian.PutUint64(frameNonce[len(frameNonce)-8:], counter)
+
+		pt, err := aead.Open(nil, frameNonce, sealed, nil)
+		if err != nil {
+			t.Fatalf("frame %d failed to authenticate: %v", frame, err)
+		}
+		plaintext = append(plaintext, pt...)
+		frame++
+	}
+	return plaintext, int(frame)
+}
+
+// TestWriteAEADSealsBodyWithoutContentLengthOrFlush is the direct
+// regression test for the data-loss bug: a handler that writes its whole
+// body in one Write call and returns, without ever setting
+// Content-Length or calling Flush, must still see its body reach the
+// wire. This is the overwhelmingly common case (c.JSON, c.String,
+// c.Data, ...).
+func TestWriteAEADSealsBodyWithoutContentLengthOrFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseWriter{}
+	w.reset(rec)
+
+	key := bytes.Repeat([]byte{0x01}, 32)
+	nonce := bytes.Repeat([]byte{0x02}, 12) // AES-GCM nonce size
+	if err := w.SetAEAD(AEADAESGCM, key, nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	body := bytes.Repeat([]byte{0x61}, 65) // well under aeadFrameSize
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	got, frames := decodeAEADWire(t, rec.Body.Bytes(), key, nonce)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("body never reached the wire without an explicit Flush: got %d plaintext bytes, want %d", len(got), len(body))
+	}
+	if frames != 1 {
+		t.Fatalf("expected a single Write to produce a single frame, got %d", frames)
+	}
+}
+
+// TestWriteAEADOrderOfContentLengthDoesNotMatter covers the exact
+// ordering the review flagged: Content-Length set after SetAEAD. Since
+// writeAEAD no longer consults Content-Length at all, this must behave
+// identically to not setting it.
+func TestWriteAEADOrderOfContentLengthDoesNotMatter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseWriter{}
+	w.reset(rec)
+
+	key := bytes.Repeat([]byte{0x01}, 32)
+	nonce := bytes.Repeat([]byte{0x02}, 12)
+	if err := w.SetAEAD(AEADAESGCM, key, nonce); err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat([]byte{0x61}, 65)
+	w.Header().Set("Content-Length", "65") // set after SetAEAD, as a render pipeline would
+
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := decodeAEADWire(t, rec.Body.Bytes(), key, nonce)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("setting Content-Length after SetAEAD should not affect whether the body is sealed")
+	}
+}
+
+// TestWriteAEADSealsEachWriteCallAsItsOwnFrame ensures no plaintext is
+// ever left pending between Write calls: streaming handlers that never
+// call Flush must not lose their final chunk either.
+func TestWriteAEADSealsEachWriteCallAsItsOwnFrame(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseWriter{}
+	w.reset(rec)
+
+	key := bytes.Repeat([]byte{0x03}, 32)
+	nonce := bytes.Repeat([]byte{0x04}, chacha20Poly1305NonceSizeForTest)
+	if err := w.SetAEAD(AEADChaCha20Poly1305, key, nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := [][]byte{
+		bytes.Repeat([]byte{0x41}, 10),
+		bytes.Repeat([]byte{0x42}, 20),
+		bytes.Repeat([]byte{0x43}, 3),
+	}
+	var want []byte
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, p...)
+	}
+
+	got, frames := decodeAEADWire(t, rec.Body.Bytes(), key, nonce)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("round-tripped plaintext across multiple Write calls did not match")
+	}
+	if frames != len(parts) {
+		t.Fatalf("expected one frame per Write call, got %d frames for %d calls", frames, len(parts))
+	}
+}
+
+// chacha20Poly1305NonceSizeForTest avoids importing
+// golang.org/x/crypto/chacha20poly1305 into the test just for its
+// NonceSize constant.
+const chacha20Poly1305NonceSizeForTest = 12
+
+// TestWriteAEADSplitsLargeWritesAcrossFrames checks a single Write
+// bigger than aeadFrameSize still round-trips, covering the "full frame
+// plus short remainder" split within one call.
+func TestWriteAEADSplitsLargeWritesAcrossFrames(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseWriter{}
+	w.reset(rec)
+
+	key := bytes.Repeat([]byte{0x05}, 32)
+	nonce := bytes.Repeat([]byte{0x06}, 12)
+	if err := w.SetAEAD(AEADAESGCM, key, nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	body := bytes.Repeat([]byte{0x61}, aeadFrameSize+100)
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	got, frames := decodeAEADWire(t, rec.Body.Bytes(), key, nonce)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("round-tripped plaintext for an oversized Write did not match")
+	}
+	if frames != 2 {
+		t.Fatalf("expected one full frame plus one short remainder frame, got %d frames", frames)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseRangeSingle(t *testing.T) {
+	ranges, err := parseRange("bytes=0-99", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0].start != 0 || ranges[0].length != 100 {
+		t.Fatalf("unexpected ranges: %+v", ranges)
+	}
+}
+
+func TestParseRangeSuffix(t *testing.T) {
+	ranges, err := parseRange("bytes=-500", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0].start != 500 || ranges[0].length != 500 {
+		t.Fatalf("unexpected ranges: %+v", ranges)
+	}
+}
+
+func buildRangeHeader(n int) string {
+	var sb strings.Builder
+	sb.WriteString("bytes=")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "%d-%d", i, i)
+	}
+	return sb.String()
+}
+
+func TestParseRangeAllowsUpToMaxRanges(t *testing.T) {
+	ranges, err := parseRange(buildRangeHeader(maxRanges), int64(maxRanges+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != maxRanges {
+		t.Fatalf("got %d ranges, want %d", len(ranges), maxRanges)
+	}
+}
+
+func TestParseRangeRejectsTooManyRanges(t *testing.T) {
+	_, err := parseRange(buildRangeHeader(maxRanges+1), int64(maxRanges+2))
+	if err == nil {
+		t.Fatal("expected an error for a Range header exceeding maxRanges, got nil")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// defaultCryptionMaxBytes bounds how much ciphertext CryptionMiddleware
+// will read into memory before rejecting the request.
+const defaultCryptionMaxBytes = 1 << 20 // 1 MiB
+
+// responseIVCounterOffset is added to the request IV (via addCounter) to
+// derive the response IV. It's far larger than any block count a request
+// bounded by defaultCryptionMaxBytes (or any sane WithMaxBytes override)
+// could ever advance the request stream by, so the two directions never
+// share a keystream position even though they share a key.
+const responseIVCounterOffset = 1 << 48
+
+var (
+	errCryptionMalformed  = errors.New("gin: malformed encrypted request body")
+	errCryptionBodyTooBig = errors.New("gin: encrypted request body exceeds MaxBytes")
+)
+
+type cryptionOptions struct {
+	maxBytes int64
+	ivHeader string
+}
+
+// CryptionOption configures CryptionMiddleware.
+type CryptionOption func(*cryptionOptions)
+
+// WithMaxBytes overrides the default 1 MiB cap on the request body as
+// read, before any base64 decoding or decryption.
+func WithMaxBytes(n int64) CryptionOption {
+	return func(o *cryptionOptions) { o.maxBytes = n }
+}
+
+// WithIVHeader overrides the header CryptionMiddleware reads the IV
+// from. It is ignored for requests that carry the IV as the first 16
+// bytes of the body instead.
+func WithIVHeader(header string) CryptionOption {
+	return func(o *cryptionOptions) { o.ivHeader = header }
+}
+
+// CryptionMiddleware decrypts an AES-CTR request body before handlers
+// see it, and arms the response writer with the same key so the reply
+// is encrypted the same way. The response IV is derived from the
+// request IV (not reused as-is) so the two directions never share a
+// keystream position. This gives symmetric encrypted request/response
+// handling behind a single Use() call, pairing with the ResponseWriter
+// encryption already exposed by EnableEncryption.
+//
+// The IV is read from the X-Encryption-IV header (see WithIVHeader) or,
+// if that header is absent, from the first aes.BlockSize bytes of the
+// body. Bodies larger than MaxBytes are rejected with 413, and
+// ciphertext that fails to decrypt is rejected with 400.
+func CryptionMiddleware(key []byte, opts ...CryptionOption) HandlerFunc {
+	o := cryptionOptions{
+		maxBytes: defaultCryptionMaxBytes,
+		ivHeader: "X-Encryption-IV",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(c *Context) {
+		if c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		body, err := readCryptionBody(c.Request.Body, o.maxBytes)
+		if err == errCryptionBodyTooBig {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(body) == 0 {
+			c.Next()
+			return
+		}
+
+		body, err = decodeCryptionTransferEncoding(body, c.GetHeader("Content-Transfer-Encoding") == "base64")
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		iv, body, err := extractCryptionIV(c.GetHeader(o.ivHeader), body)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		plain, err := decryptBody(key, iv, body)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(plain))
+		c.Request.ContentLength = int64(len(plain))
+
+		if w, ok := c.Writer.(*responseWriter); ok {
+			w.SetKey(key)
+			// Never reuse the request IV for the response: under CTR mode
+			// two streams sharing a key and IV produce the same keystream,
+			// so their ciphertexts XOR together into
+			// requestPlaintext XOR responsePlaintext with no key needed.
+			// Deriving the response IV from a distinct counter offset keeps
+			// the two directions on disjoint keystreams.
+			w.SetIV(addCounter(iv, responseIVCounterOffset))
+			w.EnableEncryption(true)
+		}
+
+		c.Next()
+	}
+}
+
+// decryptBody wraps decrypt(), which panics on malformed ciphertext,
+// and turns that into an error CryptionMiddleware can respond to.
+func decryptBody(key, iv, data []byte) (plain []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errCryptionMalformed
+		}
+	}()
+	return decrypt(key, iv, data), nil
+}
+
+// readCryptionBody reads r fully, capped at maxBytes. It reads one byte
+// past the cap so it can tell "exactly maxBytes" apart from "too big" in
+// a single read, and reports errCryptionBodyTooBig in the latter case.
+func readCryptionBody(r io.Reader, maxBytes int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, errCryptionBodyTooBig
+	}
+	return body, nil
+}
+
+// decodeCryptionTransferEncoding undoes a base64 Content-Transfer-Encoding
+// if encoded is true, and returns body unchanged otherwise.
+func decodeCryptionTransferEncoding(body []byte, encoded bool) ([]byte, error) {
+	if !encoded {
+		return body, nil
+	}
+	return base64.StdEncoding.DecodeString(string(body))
+}
+
+// extractCryptionIV resolves the IV CryptionMiddleware should decrypt
+// with: headerIV if it's non-empty, otherwise the first aes.BlockSize
+// bytes of body. It returns the resolved IV and whatever of body is left
+// to decrypt, or errCryptionMalformed if neither source yields a
+// correctly-sized IV.
+func extractCryptionIV(headerIV string, body []byte) (iv, rest []byte, err error) {
+	iv, rest = []byte(headerIV), body
+	if len(iv) == 0 {
+		if len(body) < aes.BlockSize {
+			return nil, nil, errCryptionMalformed
+		}
+		iv, rest = body[:aes.BlockSize], body[aes.BlockSize:]
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, nil, errCryptionMalformed
+	}
+	return iv, rest, nil
+}
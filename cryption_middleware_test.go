@@ -0,0 +1,154 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+// CryptionMiddleware's handler closure needs *Context, which this chunk
+// of the tree doesn't define, so these tests exercise the pure helpers
+// it's built from directly — the same approach parseRange's tests take
+// for the Context-dependent EncryptedFile.
+
+func TestExtractCryptionIVFromHeader(t *testing.T) {
+	iv := bytes.Repeat([]byte{0x09}, aes.BlockSize)
+	body := []byte("ciphertext-goes-here")
+
+	gotIV, gotRest, err := extractCryptionIV(string(iv), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotIV, iv) {
+		t.Fatalf("IV taken from the header didn't match")
+	}
+	if !bytes.Equal(gotRest, body) {
+		t.Fatalf("body should be untouched when the IV comes from a header")
+	}
+}
+
+func TestExtractCryptionIVFromBodyPrefix(t *testing.T) {
+	iv := bytes.Repeat([]byte{0x0a}, aes.BlockSize)
+	ciphertext := []byte("rest-of-the-body")
+	body := append(append([]byte{}, iv...), ciphertext...)
+
+	gotIV, gotRest, err := extractCryptionIV("", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotIV, iv) {
+		t.Fatalf("IV taken from the body prefix didn't match")
+	}
+	if !bytes.Equal(gotRest, ciphertext) {
+		t.Fatalf("body prefix wasn't stripped before returning the remainder")
+	}
+}
+
+func TestExtractCryptionIVRejectsShortBodyPrefix(t *testing.T) {
+	if _, _, err := extractCryptionIV("", []byte("too-short")); err != errCryptionMalformed {
+		t.Fatalf("got err %v, want errCryptionMalformed for a body shorter than aes.BlockSize", err)
+	}
+}
+
+func TestExtractCryptionIVRejectsWrongSizedHeaderIV(t *testing.T) {
+	if _, _, err := extractCryptionIV("not-sixteen-bytes", []byte("whatever")); err != errCryptionMalformed {
+		t.Fatalf("got err %v, want errCryptionMalformed for a header IV of the wrong length", err)
+	}
+}
+
+func TestReadCryptionBodyRejectsOversizedBody(t *testing.T) {
+	body := bytes.NewReader(bytes.Repeat([]byte{0x01}, 100))
+	if _, err := readCryptionBody(body, 50); err != errCryptionBodyTooBig {
+		t.Fatalf("got err %v, want errCryptionBodyTooBig", err)
+	}
+}
+
+func TestReadCryptionBodyAllowsBodyAtTheLimit(t *testing.T) {
+	data := bytes.Repeat([]byte{0x01}, 50)
+	got, err := readCryptionBody(bytes.NewReader(data), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("a body exactly at MaxBytes should be read in full")
+	}
+}
+
+func TestDecodeCryptionTransferEncodingBase64(t *testing.T) {
+	plain := []byte("some ciphertext bytes")
+	encoded := []byte(base64.StdEncoding.EncodeToString(plain))
+
+	got, err := decodeCryptionTransferEncoding(encoded, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("base64 body wasn't decoded correctly")
+	}
+}
+
+func TestDecodeCryptionTransferEncodingRejectsInvalidBase64(t *testing.T) {
+	if _, err := decodeCryptionTransferEncoding([]byte("not valid base64!!"), true); err == nil {
+		t.Fatal("expected an error decoding invalid base64")
+	}
+}
+
+func TestDecodeCryptionTransferEncodingPassesThroughWhenNotEncoded(t *testing.T) {
+	body := []byte("raw ciphertext")
+	got, err := decodeCryptionTransferEncoding(body, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("body should pass through unchanged when not base64-encoded")
+	}
+}
+
+func TestDecryptBodyRejectsCorruptCiphertext(t *testing.T) {
+	key := bytes.Repeat([]byte{0x02}, 16)
+	iv := bytes.Repeat([]byte{0x03}, aes.BlockSize)
+
+	if _, err := decryptBody(key, iv, []byte("short")); err != errCryptionMalformed {
+		t.Fatalf("got err %v, want errCryptionMalformed for ciphertext shorter than a block", err)
+	}
+}
+
+func TestDecryptBodyRoundTripsValidCiphertext(t *testing.T) {
+	key := bytes.Repeat([]byte{0x04}, 16)
+	iv := bytes.Repeat([]byte{0x05}, aes.BlockSize)
+	plain := []byte("hello, encrypted world")
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plain)
+
+	got, err := decryptBody(key, iv, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decryptBody round-trip mismatch: got %q, want %q", got, plain)
+	}
+}
+
+func TestResponseIVNeverMatchesRequestIV(t *testing.T) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+
+	responseIV := addCounter(iv, responseIVCounterOffset)
+	if bytes.Equal(responseIV, iv) {
+		t.Fatalf("response IV must never equal the request IV")
+	}
+}
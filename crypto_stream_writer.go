@@ -0,0 +1,58 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"io"
+	"sync"
+)
+
+// scratchBufSize is the size of the pooled buffers cryptoStreamWriter
+// XORs into before handing bytes off to the underlying writer.
+const scratchBufSize = 32 * 1024
+
+var scratchBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, scratchBufSize)
+		return &buf
+	},
+}
+
+// cryptoStreamWriter streams data through a StreamCipher and out to dst,
+// in the spirit of crypto/cipher.StreamWriter but generalized to any
+// StreamCipher rather than a concrete cipher.Stream, so it keeps
+// working with ciphers installed via SetCipher. CTR-family ciphers are
+// byte-oriented, so no block-alignment padding is needed here at all;
+// Write draws its scratch buffer from a sync.Pool so encrypting a
+// response body doesn't allocate per call.
+type cryptoStreamWriter struct {
+	dst    io.Writer
+	cipher StreamCipher
+}
+
+func (s cryptoStreamWriter) Write(src []byte) (n int, err error) {
+	bufp := scratchBufPool.Get().(*[]byte)
+	defer scratchBufPool.Put(bufp)
+	buf := *bufp
+
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > len(buf) {
+			chunk = chunk[:len(buf)]
+		}
+
+		s.cipher.XORKeyStream(buf[:len(chunk)], chunk)
+		written, werr := s.dst.Write(buf[:len(chunk)])
+		n += written
+		if werr != nil {
+			return n, werr
+		}
+		if written != len(chunk) {
+			return n, io.ErrShortWrite
+		}
+		src = src[len(chunk):]
+	}
+	return n, nil
+}
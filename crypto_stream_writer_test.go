@@ -0,0 +1,41 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"crypto/aes"
+	"io/ioutil"
+	"testing"
+)
+
+func benchmarkCryptoStreamWriterWrite(b *testing.B, size int) {
+	c := &aesCTRCipher{}
+	if err := c.Init(bytes.Repeat([]byte{0x5a}, 16), make([]byte, aes.BlockSize)); err != nil {
+		b.Fatal(err)
+	}
+	w := cryptoStreamWriter{dst: ioutil.Discard, cipher: c}
+	data := make([]byte, size)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCryptoStreamWriter_1KiB(b *testing.B) {
+	benchmarkCryptoStreamWriterWrite(b, 1024)
+}
+
+func BenchmarkCryptoStreamWriter_64KiB(b *testing.B) {
+	benchmarkCryptoStreamWriterWrite(b, 64*1024)
+}
+
+func BenchmarkCryptoStreamWriter_1MiB(b *testing.B) {
+	benchmarkCryptoStreamWriterWrite(b, 1024*1024)
+}
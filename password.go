@@ -0,0 +1,86 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// EncryptionMethod selects the AES key size SetPassword derives.
+type EncryptionMethod uint8
+
+const (
+	// AES128 derives a 16-byte key.
+	AES128 EncryptionMethod = iota + 1
+	// AES192 derives a 24-byte key.
+	AES192
+	// AES256 derives a 32-byte key.
+	AES256
+)
+
+// defaultPBKDF2Iterations matches the WinZip AES iteration count, so
+// SetPassword stays interoperable with the WinZip key-derivation scheme
+// by default.
+const defaultPBKDF2Iterations = 1000
+
+var errUnsupportedEncryptionMethod = errors.New("gin: unsupported EncryptionMethod")
+
+// keyLength returns the AES key size in bytes for m, or 0 if m is not
+// one of the defined constants.
+func (m EncryptionMethod) keyLength() int {
+	switch m {
+	case AES128:
+		return 16
+	case AES192:
+		return 24
+	case AES256:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// SetPassword derives the AES key (and a companion HMAC integrity key)
+// from password via PBKDF2-HMAC-SHA1, using the same construction as
+// WinZip AE-2: a fixed 1000 iterations (defaultPBKDF2Iterations; the
+// count is not configurable through SetPassword), and the derived material is
+// split into an AES key of method's length, an HMAC-SHA1 key of the
+// same length, and a 2-byte password-verification value.
+//
+// If salt is nil, a random salt of half method's key length is
+// generated, matching the WinZip AE-2 spec (8/12/16 bytes for
+// AES-128/192/256). The salt and verification value are written once into the
+// X-Encryption-Salt and X-Key-Verifier response headers so a client
+// holding the same password can re-derive the key and confirm it
+// guessed the password correctly before attempting to decrypt.
+func (w *responseWriter) SetPassword(password string, salt []byte, method EncryptionMethod) error {
+	keyLen := method.keyLength()
+	if keyLen == 0 {
+		return errUnsupportedEncryptionMethod
+	}
+
+	if salt == nil {
+		// WinZip AE-2 uses a salt half the AES key length (8/12/16 bytes
+		// for AES-128/192/256).
+		salt = make([]byte, keyLen/2)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+	}
+
+	derived := pbkdf2.Key([]byte(password), salt, defaultPBKDF2Iterations, 2*keyLen+2, sha1.New)
+	w.SetKey(derived[:keyLen])
+	w.hmacKey = derived[keyLen : 2*keyLen]
+	verifier := derived[2*keyLen:]
+
+	w.Header().Set("X-Encryption-Salt", hex.EncodeToString(salt))
+	w.Header().Set("X-Key-Verifier", hex.EncodeToString(verifier))
+	return nil
+}
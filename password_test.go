@@ -0,0 +1,81 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestSetPasswordWithExplicitSalt(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseWriter{}
+	w.reset(rec)
+
+	salt := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08} // 8 bytes, AES128's keyLen/2
+	password := "correct horse battery staple"
+	if err := w.SetPassword(password, salt, AES128); err != nil {
+		t.Fatal(err)
+	}
+
+	wantDerived := pbkdf2.Key([]byte(password), salt, defaultPBKDF2Iterations, 2*16+2, sha1.New)
+	wantKey, wantHMACKey, wantVerifier := wantDerived[:16], wantDerived[16:32], wantDerived[32:]
+
+	if !bytes.Equal(w.pendingKey, wantKey) {
+		t.Fatalf("SetPassword installed a different AES key than PBKDF2-HMAC-SHA1 derives")
+	}
+	if !bytes.Equal(w.hmacKey, wantHMACKey) {
+		t.Fatalf("SetPassword installed a different HMAC key than PBKDF2-HMAC-SHA1 derives")
+	}
+	if got := w.Header().Get("X-Encryption-Salt"); got != hex.EncodeToString(salt) {
+		t.Fatalf("X-Encryption-Salt header = %q, want %q", got, hex.EncodeToString(salt))
+	}
+	if got := w.Header().Get("X-Key-Verifier"); got != hex.EncodeToString(wantVerifier) {
+		t.Fatalf("X-Key-Verifier header = %q, want %q", got, hex.EncodeToString(wantVerifier))
+	}
+}
+
+func TestSetPasswordGeneratesWinZipSizedSaltWhenNil(t *testing.T) {
+	for _, tc := range []struct {
+		method       EncryptionMethod
+		wantSaltSize int
+	}{
+		{AES128, 8},
+		{AES192, 12},
+		{AES256, 16},
+	} {
+		rec := httptest.NewRecorder()
+		w := &responseWriter{}
+		w.reset(rec)
+
+		if err := w.SetPassword("hunter2", nil, tc.method); err != nil {
+			t.Fatal(err)
+		}
+
+		saltHex := w.Header().Get("X-Encryption-Salt")
+		salt, err := hex.DecodeString(saltHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(salt) != tc.wantSaltSize {
+			t.Fatalf("method %v: generated salt is %d bytes, want %d (WinZip AE-2 uses keyLen/2)", tc.method, len(salt), tc.wantSaltSize)
+		}
+	}
+}
+
+func TestSetPasswordRejectsUnsupportedMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseWriter{}
+	w.reset(rec)
+
+	if err := w.SetPassword("hunter2", nil, EncryptionMethod(0)); err != errUnsupportedEncryptionMethod {
+		t.Fatalf("got err %v, want errUnsupportedEncryptionMethod", err)
+	}
+}
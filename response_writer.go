@@ -9,6 +9,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"io"
 	"net"
 	"net/http"
@@ -19,6 +20,11 @@ const (
 	defaultStatus = http.StatusOK
 )
 
+var (
+	errSeekNoCipher   = errors.New("gin: Seek requires a StreamCipher installed via SetKey/SetIV/SetCipher first")
+	errSeekAEADActive = errors.New("gin: Seek is not supported while AEAD encryption is active; use SetStartIndex with a frame-aligned offset instead")
+)
+
 // ResponseWriter ...
 type ResponseWriter interface {
 	http.ResponseWriter
@@ -56,8 +62,33 @@ type ResponseWriter interface {
 
 	//sets start index in file (in byte order)
 	SetStartIndex(uint64)
+
+	// Seek repositions the encrypted stream to the given plaintext byte
+	// offset, ready for the next Write. Only meaningful once a cipher
+	// has been installed via SetKey/SetIV/SetCipher; use it to resume
+	// mid-stream, e.g. for a ranged GET.
+	Seek(offset uint64) error
+
 	// get the http.Pusher for server push
 	Pusher() http.Pusher
+
+	// SetAEAD switches the writer into authenticated-encryption mode,
+	// framing the body into length-prefixed, individually-tagged chunks
+	// of at most 64 KiB (one or more per Write call) instead of the raw
+	// CTR keystream used by EnableEncryption. It
+	// returns an error if algo is unsupported or key/nonce have the
+	// wrong length for it.
+	SetAEAD(algo AEADAlgo, key, nonce []byte) error
+
+	// SetPassword derives the encryption key from password via PBKDF2
+	// instead of requiring the caller to manage raw key bytes. See
+	// EncryptionMethod for the supported AES key sizes.
+	SetPassword(password string, salt []byte, method EncryptionMethod) error
+
+	// SetCipher installs an already-initialized StreamCipher, letting
+	// callers plug in an encryption scheme other than the built-in
+	// AES-CTR default without forking gin.
+	SetCipher(StreamCipher)
 }
 
 type responseWriter struct {
@@ -65,13 +96,30 @@ type responseWriter struct {
 	size   int
 	status int
 	encryptionParams
+	aeadState
 }
 
 type encryptionParams struct {
-	key              []byte
-	iv               []byte
+	// cipher is the active keystream generator for EnableEncryption.
+	// SetKey/SetIV build the default AES-CTR implementation from it;
+	// SetCipher lets callers install any other StreamCipher directly.
+	cipher StreamCipher
+	// pendingKey/pendingNonce hold whichever of SetKey/SetIV was called
+	// most recently while waiting for its counterpart, since either can
+	// arrive first.
+	pendingKey       []byte
+	pendingNonce     []byte
 	enableEncryption bool
-	startIndex       uint64
+	// startIndex records the plaintext byte offset the stream is
+	// currently positioned at. In CTR mode it is bookkeeping for the
+	// caller (e.g. Content-Range) and Seek is what actually keeps the
+	// cipher in sync with it; under AEAD it picks the first frame index
+	// (see writeAEAD).
+	startIndex uint64
+	// hmacKey is the integrity key derived alongside key by SetPassword.
+	// It is stored only: the writer does not use it to authenticate the
+	// body, and no exported method returns it.
+	hmacKey []byte
 }
 
 var _ ResponseWriter = &responseWriter{}
@@ -81,6 +129,12 @@ func (w *responseWriter) reset(writer http.ResponseWriter) {
 	w.size = noWritten
 	w.status = defaultStatus
 	w.enableEncryption = false
+	w.cipher = nil
+	w.pendingKey = nil
+	w.pendingNonce = nil
+	w.startIndex = 0
+	w.hmacKey = nil
+	w.aeadState = aeadState{}
 }
 
 func (w *responseWriter) WriteHeader(code int) {
@@ -103,17 +157,10 @@ func (w *responseWriter) Write(data []byte) (n int, err error) {
 	//println("data length is", len(data), " bytes")
 	w.WriteHeaderNow()
 	//n, err = w.ResponseWriter.Write(data)
-	if w.enableEncryption {
-		var encrypted []byte
-
-		appendedData, appOffset, _ := w.appendIfNeeded(data)
-		paddedData, prepOffset, _ := w.prependIfNeeded(appendedData)
-		//println("data length becomes", len(prependedData), "offset is", offset)
-
-		encrypted = encrypt(w.key, w.iv, paddedData)
-		dataEnd := uint64(len(paddedData)) - appOffset
-		n, err = w.ResponseWriter.Write(encrypted[prepOffset:dataEnd])
-		w.iv = addCounter(w.iv, uint64(n/aes.BlockSize))
+	if w.aead != nil {
+		n, err = w.writeAEAD(data)
+	} else if w.enableEncryption {
+		n, err = (cryptoStreamWriter{dst: w.ResponseWriter, cipher: w.cipher}).Write(data)
 	} else {
 		n, err = w.ResponseWriter.Write(data)
 	}
@@ -145,6 +192,9 @@ func (w *responseWriter) Written() bool {
 
 // Hijack implements the customhttp.Hijacker interface.
 func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.aead != nil {
+		return nil, nil, errAEADHijack
+	}
 	if w.size < 0 {
 		w.size = 0
 	}
@@ -170,69 +220,83 @@ func (w *responseWriter) EncryptionEnabled() bool {
 	return w.enableEncryption
 }
 
+// SetKey sets the encryption key used by the default AES-CTR
+// StreamCipher. It takes effect once SetIV has also been called (in
+// either order); SetCipher bypasses this entirely for other ciphers.
 func (w *responseWriter) SetKey(key []byte) {
-	w.key = key
+	w.pendingKey = key
+	w.rebuildDefaultCipher()
 }
 
+// SetIV sets the nonce/IV used by the default AES-CTR StreamCipher. See
+// SetKey.
 func (w *responseWriter) SetIV(iv []byte) {
-	w.iv = iv
+	w.pendingNonce = iv
+	w.rebuildDefaultCipher()
 }
 
-func (w *responseWriter) SetStartIndex(index uint64) {
-	w.startIndex = index
-}
-
-func (w *responseWriter) appendIfNeeded(data []byte) (appendedData []byte, appendedBytes uint64, err error) {
-	appendedBytes = 16 - (w.startIndex+uint64(w.size)+uint64(len(data)))%16
-	if appendedBytes > 0 && appendedBytes < 16 {
-		//fmt.Printf("index %d, appending %d", (int(w.startIndex) + w.size), appendedBytes)
-		bytesToAppend := make([]byte, appendedBytes)
-		appendedData = append(data, bytesToAppend...)
-		return appendedData, appendedBytes, err
-	} else {
-		return data, 0, err
+// rebuildDefaultCipher (re)builds the default AES-CTR cipher once both
+// a key and a nonce are available, so SetKey/SetIV can be called in
+// either order and SetIV can be called again mid-response (e.g. to
+// re-key a subsequent byte range) without also repeating SetKey.
+func (w *responseWriter) rebuildDefaultCipher() {
+	if w.pendingKey == nil || w.pendingNonce == nil {
+		return
+	}
+	c := &aesCTRCipher{}
+	if err := c.Init(w.pendingKey, w.pendingNonce); err != nil {
+		debugPrint("[WARNING] failed to initialize default AES-CTR cipher: %v", err)
+		return
 	}
+	w.cipher = c
 }
 
-func (w *responseWriter) prependIfNeeded(data []byte) (prependedData []byte, prependedBytes uint64, err error) {
-	prependedBytes = (w.startIndex + uint64(w.size)) % 16
-	if prependedBytes > 0 {
-		//fmt.Printf(" prepending %d\n", prependedBytes)
-		bytesToPrepend := make([]byte, prependedBytes)
-		//_, err := w.file.ReadAt(bytesToPrepend, int64(w.startIndex - prependedBytes))
-		//if err != nil {
-		//	return nil,0, err
-		//}
-		//for i := 0; i < len(bytesToPrepend); i++ {
-		//	bytesToPrepend[i] = 0
-		//}
-
-		prependedData = append(bytesToPrepend, data...)
-		return prependedData, prependedBytes, err
-	} else {
-		return data, prependedBytes, err
-	}
+// SetCipher installs an already-initialized StreamCipher, letting
+// callers plug in an encryption scheme other than the built-in AES-CTR
+// default without forking gin.
+func (w *responseWriter) SetCipher(c StreamCipher) {
+	w.cipher = c
 }
 
-// encrypt using AES/CTR/NoPadding
-func encrypt(key []byte, iv []byte, data []byte) []byte {
-	// key := []byte(keyText)
-	//plaintext := []byte(text)
+// SetStartIndex records the plaintext byte offset the caller is about
+// to write from. In CTR mode it's bookkeeping only; call Seek to
+// actually reposition the cipher there. While AEAD is active, index
+// must be a multiple of aeadFrameSize (the first Write fails
+// otherwise), and the first frame is sealed with frame index
+// index/aeadFrameSize.
+func (w *responseWriter) SetStartIndex(index uint64) {
+	w.startIndex = index
+}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		println("error during encryption, panicking")
-		panic(err)
+// Seek repositions the encrypted stream's keystream to offset. CTR-mode
+// ciphers can only seek to a block boundary, so Seek advances the
+// cipher to the block containing offset and then discards the leading
+// discard bytes of that block's keystream, leaving the stream aligned
+// exactly on offset for the Writes that follow. Because that alignment
+// then holds for every subsequent Write, this replaces the old
+// appendIfNeeded/prependIfNeeded dance that redid the same block math
+// on every single Write call.
+func (w *responseWriter) Seek(offset uint64) error {
+	if w.aead != nil {
+		return errSeekAEADActive
+	}
+	if w.cipher == nil {
+		return errSeekNoCipher
 	}
 
-	encrypted := make([]byte, len(data))
-
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(encrypted, data)
-
-	//v := reflect.ValueOf(stream).Elem()
+	blockSize := uint64(w.cipher.BlockSize())
+	blockOffset := offset / blockSize
+	discard := offset % blockSize
 
-	return encrypted //, v.FieldByName("ctr").Bytes()
+	if err := w.cipher.Seek(blockOffset); err != nil {
+		return err
+	}
+	if discard > 0 {
+		scratch := make([]byte, discard)
+		w.cipher.XORKeyStream(scratch, scratch)
+	}
+	w.startIndex = offset
+	return nil
 }
 
 // decrypt from hex to decrypted string
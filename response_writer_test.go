@@ -0,0 +1,47 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSeekWithoutACipherReturnsAnErrorInsteadOfPanicking(t *testing.T) {
+	w := &responseWriter{}
+	w.reset(httptest.NewRecorder())
+
+	if err := w.Seek(16); err != errSeekNoCipher {
+		t.Fatalf("got err %v, want errSeekNoCipher", err)
+	}
+}
+
+func TestSeekWhileAEADIsActiveReturnsAnErrorInsteadOfPanicking(t *testing.T) {
+	w := &responseWriter{}
+	w.reset(httptest.NewRecorder())
+
+	key := bytes.Repeat([]byte{0x01}, 32)
+	nonce := bytes.Repeat([]byte{0x02}, 12)
+	if err := w.SetAEAD(AEADAESGCM, key, nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Seek(aeadFrameSize); err != errSeekAEADActive {
+		t.Fatalf("got err %v, want errSeekAEADActive", err)
+	}
+}
+
+func TestSeekWorksOnceACipherIsInstalled(t *testing.T) {
+	w := &responseWriter{}
+	w.reset(httptest.NewRecorder())
+
+	w.SetKey(bytes.Repeat([]byte{0x03}, 16))
+	w.SetIV(bytes.Repeat([]byte{0x04}, 16))
+
+	if err := w.Seek(32); err != nil {
+		t.Fatalf("unexpected error seeking with a cipher installed: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"math"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// StreamCipher abstracts the keystream generator behind the
+// EnableEncryption path, so responseWriter's Write/Seek don't need to
+// know which cipher produced the bytes XORed against the response body.
+type StreamCipher interface {
+	// Init resets the cipher to the start of the stream identified by
+	// key and nonce.
+	Init(key, nonce []byte) error
+	// XORKeyStream XORs len(src) bytes of keystream into src and writes
+	// the result to dst, like cipher.Stream.
+	XORKeyStream(dst, src []byte)
+	// Seek repositions the keystream to blockOffset blocks past the
+	// nonce passed to Init, as if that many blocks had already been
+	// consumed.
+	Seek(blockOffset uint64) error
+	// BlockSize reports the cipher's block size in bytes, which is what
+	// responseWriter.Seek aligns to when resuming mid-stream.
+	BlockSize() int
+}
+
+// aesCTRCipher is the default StreamCipher: AES in CTR mode with a
+// big-endian counter in the low 64 bits of the nonce, matching the
+// behavior EnableEncryption/SetKey/SetIV have always had. The
+// cipher.Block and cipher.Stream are built once in Init and then just
+// keep advancing across XORKeyStream calls, the way CTR mode is meant
+// to be used; Seek is the only thing that ever rebuilds the stream.
+type aesCTRCipher struct {
+	block     cipher.Block
+	baseNonce []byte
+	stream    cipher.Stream
+}
+
+func (c *aesCTRCipher) Init(key, nonce []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	c.block = block
+	c.baseNonce = append([]byte(nil), nonce...)
+	c.stream = cipher.NewCTR(block, nonce)
+	return nil
+}
+
+func (c *aesCTRCipher) XORKeyStream(dst, src []byte) {
+	c.stream.XORKeyStream(dst, src)
+}
+
+func (c *aesCTRCipher) Seek(blockOffset uint64) error {
+	c.stream = cipher.NewCTR(c.block, addCounter(c.baseNonce, blockOffset))
+	return nil
+}
+
+func (c *aesCTRCipher) BlockSize() int {
+	return aes.BlockSize
+}
+
+// chacha20Cipher is a StreamCipher backed by golang.org/x/crypto/chacha20.
+type chacha20Cipher struct {
+	key   []byte
+	nonce []byte
+	c     *chacha20.Cipher
+}
+
+func (c *chacha20Cipher) Init(key, nonce []byte) error {
+	cc, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return err
+	}
+	c.key = key
+	c.nonce = nonce
+	c.c = cc
+	return nil
+}
+
+func (c *chacha20Cipher) XORKeyStream(dst, src []byte) {
+	c.c.XORKeyStream(dst, src)
+}
+
+func (c *chacha20Cipher) Seek(blockOffset uint64) error {
+	if blockOffset > math.MaxUint32 {
+		return errors.New("gin: chacha20 block offset out of range")
+	}
+	fresh, err := chacha20.NewUnauthenticatedCipher(c.key, c.nonce)
+	if err != nil {
+		return err
+	}
+	fresh.SetCounter(uint32(blockOffset))
+	c.c = fresh
+	return nil
+}
+
+// chacha20BlockSize is ChaCha20's block size in bytes. x/crypto/chacha20
+// doesn't export this as a constant, but it's fixed by the algorithm.
+const chacha20BlockSize = 64
+
+func (c *chacha20Cipher) BlockSize() int {
+	return chacha20BlockSize
+}
+
+// winzipCTRCipher implements the WinZip AES CTR variant: a 16-byte
+// little-endian counter block that starts at the nonce and increments
+// across its full width, rather than AES-CTR's big-endian counter in
+// just the low half of the IV. It's meant for interop with WinZip-style
+// AE-1/AE-2 payloads.
+//
+// Go's cipher.NewCTR always increments its counter big-endian, last byte
+// first, regardless of what's in the starting block, so it can't produce
+// WinZip's keystream past the first block. This cipher instead encrypts
+// the counter block directly with the AES block cipher, one block at a
+// time, and advances the counter itself via winzipAddCounter.
+type winzipCTRCipher struct {
+	block       cipher.Block
+	baseCounter []byte
+	counter     []byte // current 16-byte counter block
+	keystream   []byte // unconsumed keystream bytes from the last block
+}
+
+func (c *winzipCTRCipher) Init(key, nonce []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	c.block = block
+	c.baseCounter = append([]byte(nil), nonce...)
+	c.counter = append([]byte(nil), nonce...)
+	c.keystream = nil
+	return nil
+}
+
+func (c *winzipCTRCipher) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if len(c.keystream) == 0 {
+			block := make([]byte, aes.BlockSize)
+			c.block.Encrypt(block, c.counter)
+			c.keystream = block
+			c.counter = winzipAddCounter(c.counter, 1)
+		}
+		dst[i] = src[i] ^ c.keystream[0]
+		c.keystream = c.keystream[1:]
+	}
+}
+
+func (c *winzipCTRCipher) Seek(blockOffset uint64) error {
+	c.counter = winzipAddCounter(c.baseCounter, blockOffset)
+	c.keystream = nil
+	return nil
+}
+
+func (c *winzipCTRCipher) BlockSize() int {
+	return aes.BlockSize
+}
+
+// winzipAddCounter adds n to the 16-byte counter block, treating it as
+// a little-endian integer as WinZip's AES CTR mode does.
+func winzipAddCounter(counter []byte, n uint64) []byte {
+	result := append([]byte(nil), counter...)
+	carry := n
+	for i := 0; i < len(result) && carry > 0; i++ {
+		sum := uint64(result[i]) + carry
+		result[i] = byte(sum)
+		carry = sum >> 8
+	}
+	return result
+}
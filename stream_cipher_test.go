@@ -0,0 +1,94 @@
+// Copyright 2014 Manu Martinez-Almeida.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gin
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+)
+
+func TestAESCTRCipherSeekMatchesSequentialStream(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	nonce := bytes.Repeat([]byte{0x00}, aes.BlockSize)
+
+	full := make([]byte, 4*aes.BlockSize)
+	sequential := &aesCTRCipher{}
+	if err := sequential.Init(key, nonce); err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, len(full))
+	sequential.XORKeyStream(want, full)
+
+	seeked := &aesCTRCipher{}
+	if err := seeked.Init(key, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := seeked.Seek(2); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 2*aes.BlockSize)
+	seeked.XORKeyStream(got, full[2*aes.BlockSize:])
+
+	if !bytes.Equal(got, want[2*aes.BlockSize:]) {
+		t.Fatalf("Seek(2) produced a different keystream than sequential streaming to the same offset")
+	}
+}
+
+func TestWinzipCTRCipherIncrementsLittleEndian(t *testing.T) {
+	key := bytes.Repeat([]byte{0x11}, 16)
+	// A counter whose low byte is 0xFF forces a carry on increment. A
+	// little-endian counter carries into byte 1; Go's big-endian
+	// cipher.NewCTR would instead carry into byte 15 and leave byte 0
+	// untouched, so this distinguishes the two.
+	nonce := append([]byte{0xFF}, make([]byte, aes.BlockSize-1)...)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSecondBlockCounter := append([]byte{0x00, 0x01}, make([]byte, aes.BlockSize-2)...)
+	wantKeystream := make([]byte, aes.BlockSize)
+	block.Encrypt(wantKeystream, wantSecondBlockCounter)
+
+	c := &winzipCTRCipher{}
+	if err := c.Init(key, nonce); err != nil {
+		t.Fatal(err)
+	}
+	src := make([]byte, 2*aes.BlockSize)
+	got := make([]byte, 2*aes.BlockSize)
+	c.XORKeyStream(got, src)
+
+	if !bytes.Equal(got[aes.BlockSize:], wantKeystream) {
+		t.Fatalf("winzipCTRCipher did not carry the counter little-endian across the first block boundary")
+	}
+}
+
+func TestWinzipCTRCipherSeekMatchesSequentialStream(t *testing.T) {
+	key := bytes.Repeat([]byte{0x7a}, 24)
+	nonce := bytes.Repeat([]byte{0xAB}, aes.BlockSize)
+
+	full := make([]byte, 4*aes.BlockSize)
+	sequential := &winzipCTRCipher{}
+	if err := sequential.Init(key, nonce); err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, len(full))
+	sequential.XORKeyStream(want, full)
+
+	seeked := &winzipCTRCipher{}
+	if err := seeked.Init(key, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := seeked.Seek(3); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, aes.BlockSize)
+	seeked.XORKeyStream(got, full[3*aes.BlockSize:])
+
+	if !bytes.Equal(got, want[3*aes.BlockSize:]) {
+		t.Fatalf("Seek(3) produced a different keystream than sequential streaming to the same offset")
+	}
+}